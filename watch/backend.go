@@ -0,0 +1,51 @@
+// Copyright 2014 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package watch
+
+import "gopkg.in/fsnotify.v1"
+
+// Backend is the source of watch events a Watcher dispatches. The default
+// backend wraps fsnotify; NewPollingWatcher provides one that works on
+// filesystems where fsnotify events are unreliable or unsupported, such as
+// NFS, SMB, overlayfs or WSL.
+type Backend interface {
+	Add(name string) error
+	Remove(name string) error
+	Events() <-chan fsnotify.Event
+	Errors() <-chan error
+	Close() error
+}
+
+// RecursiveBackend is implemented by backends that can cover an entire
+// directory tree with a single underlying watch, such as FSEvents on
+// darwin. WatchRecursive calls AddRecursive once per root so the backend
+// can register one stream for the whole subtree; the per-directory Add
+// calls the walk still makes against paths already covered by that root
+// are expected to become no-ops.
+type RecursiveBackend interface {
+	Backend
+	AddRecursive(root string) error
+}
+
+// fsnotifyBackend adapts *fsnotify.Watcher, whose Events/Errors are fields
+// rather than methods, to the Backend interface.
+type fsnotifyBackend struct {
+	wchr *fsnotify.Watcher
+}
+
+func newFsnotifyBackend() (*fsnotifyBackend, error) {
+	wchr, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return &fsnotifyBackend{wchr: wchr}, nil
+}
+
+func (b *fsnotifyBackend) Add(name string) error    { return b.wchr.Add(name) }
+func (b *fsnotifyBackend) Remove(name string) error { return b.wchr.Remove(name) }
+func (b *fsnotifyBackend) Close() error             { return b.wchr.Close() }
+
+func (b *fsnotifyBackend) Events() <-chan fsnotify.Event { return b.wchr.Events }
+func (b *fsnotifyBackend) Errors() <-chan error          { return b.wchr.Errors }
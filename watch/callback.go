@@ -0,0 +1,54 @@
+// Copyright 2014 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package watch
+
+import "gopkg.in/fsnotify.v1"
+
+type (
+	// Event is a single change on a watched path, along with the raw
+	// bitmask of what happened to it.
+	Event = fsnotify.Event
+
+	// Op is the bitmask of change kinds a callback can be notified
+	// about: Create, Write, Remove, Rename and Chmod.
+	Op = fsnotify.Op
+)
+
+// Op bits, re-exported from fsnotify so callers don't need to import it
+// themselves.
+const (
+	Create = fsnotify.Create
+	Write  = fsnotify.Write
+	Remove = fsnotify.Remove
+	Rename = fsnotify.Rename
+	Chmod  = fsnotify.Chmod
+)
+
+// EventCallback receives the raw Op bitmask for every event on a watched
+// path, including Chmod, which the legacy File*Callback interfaces have
+// no way to express.
+type EventCallback interface {
+	OnEvent(Event)
+}
+
+// funcCallback adapts a plain function to EventCallback, filtering to the
+// Op bits the caller asked for.
+type funcCallback struct {
+	op Op
+	fn func(Event)
+}
+
+func (f *funcCallback) OnEvent(ev Event) {
+	if ev.Op&f.op != 0 {
+		f.fn(ev)
+	}
+}
+
+// WatchFunc is Watch for callers who'd rather pass a function than
+// implement one of the File*Callback interfaces. op is the bitwise-or of
+// the event kinds fn should be called for, e.g. Create|Write.
+func (w *Watcher) WatchFunc(name string, op Op, fn func(Event)) error {
+	return w.Watch(name, &funcCallback{op: op, fn: fn})
+}
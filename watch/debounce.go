@@ -0,0 +1,182 @@
+// Copyright 2014 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gopkg.in/fsnotify.v1"
+)
+
+// debouncer buffers events per path for a quiet window and collapses
+// bursts (the write-temp/rename/chmod/remove-backup dance editors do on
+// save) into a single callback invocation, so callers don't each need
+// their own timers.
+type debouncer struct {
+	sync.Mutex
+	w       *Watcher
+	window  time.Duration
+	pending map[string]*pendingEntry
+	// exits tracks, per directory, the most recent unmatched Rename seen
+	// in that directory, along with a stat snapshot of the renamed-away
+	// path (if we have one cached in known), so a following Create can be
+	// verified as the other half of that specific rename, by inode,
+	// rather than assumed to be just because it landed in the same dir.
+	exits map[string]exitInfo
+	// known caches the last stat we took of a path while handling an
+	// event on it, so the inode is still available once Rename fires and
+	// the old path itself no longer resolves.
+	known map[string]os.FileInfo
+}
+
+type exitInfo struct {
+	name string
+	info os.FileInfo // nil if we never managed to stat the path before it was renamed away
+}
+
+type pendingEntry struct {
+	op    fsnotify.Op
+	timer *time.Timer
+}
+
+func newDebouncer(w *Watcher, window time.Duration) *debouncer {
+	return &debouncer{
+		w:       w,
+		window:  window,
+		pending: make(map[string]*pendingEntry),
+		exits:   make(map[string]exitInfo),
+		known:   make(map[string]os.FileInfo),
+	}
+}
+
+// handle buffers ev, coalescing it with any already-pending event on the
+// same path, and schedules (or reschedules) delivery after the quiet
+// window.
+func (d *debouncer) handle(ev fsnotify.Event) {
+	d.Lock()
+	defer d.Unlock()
+
+	if fi, err := os.Lstat(ev.Name); err == nil {
+		d.known[ev.Name] = fi
+		name := ev.Name
+		time.AfterFunc(d.window, func() { d.clearKnown(name, fi) })
+	}
+
+	dir := filepath.Dir(ev.Name)
+	if ev.Op&fsnotify.Create != 0 {
+		if exit, ok := d.exits[dir]; ok {
+			if newFI, known := d.known[ev.Name]; known && exit.info != nil && os.SameFile(exit.info, newFI) {
+				// Verified: this Create is the other half of the
+				// pending Rename, not an unrelated file landing in the
+				// same directory; drop it rather than firing its own
+				// event.
+				delete(d.exits, dir)
+				delete(d.known, exit.name)
+				return
+			}
+		}
+	}
+	if ev.Op&fsnotify.Rename != 0 {
+		d.exits[dir] = exitInfo{name: ev.Name, info: d.known[ev.Name]}
+		name := ev.Name
+		time.AfterFunc(d.window, func() { d.clearExit(dir, name) })
+	}
+	if ev.Op&fsnotify.Remove != 0 {
+		delete(d.known, ev.Name)
+	}
+
+	if p, ok := d.pending[ev.Name]; ok {
+		op, drop := coalesce(p.op, ev.Op)
+		if drop {
+			d.cancel(ev.Name)
+			return
+		}
+		p.op = op
+		p.timer.Reset(d.window)
+		return
+	}
+	d.set(ev.Name, ev.Op)
+}
+
+// coalesce merges a newly observed op into the one already pending for a
+// path, reporting whether the pair cancels out entirely (e.g. a file
+// created and removed again before the window closes).
+func coalesce(pending, next fsnotify.Op) (merged fsnotify.Op, drop bool) {
+	switch {
+	case pending&fsnotify.Create != 0 && next&fsnotify.Remove != 0:
+		return 0, true
+	case pending&fsnotify.Create != 0 && next&fsnotify.Write != 0:
+		return fsnotify.Create, false
+	case pending&fsnotify.Write != 0 && next&fsnotify.Write != 0:
+		return fsnotify.Write, false
+	default:
+		return next, false
+	}
+}
+
+func (d *debouncer) set(name string, op fsnotify.Op) {
+	p := &pendingEntry{op: op}
+	p.timer = time.AfterFunc(d.window, func() { d.flush(name) })
+	d.pending[name] = p
+}
+
+func (d *debouncer) cancel(name string) {
+	if p, ok := d.pending[name]; ok {
+		p.timer.Stop()
+		delete(d.pending, name)
+	}
+}
+
+func (d *debouncer) clearExit(dir, name string) {
+	d.Lock()
+	defer d.Unlock()
+	if exit, ok := d.exits[dir]; ok && exit.name == name {
+		delete(d.exits, dir)
+	}
+}
+
+// clearKnown drops name's cached stat once it's old enough that it can no
+// longer pair a Rename with a matching Create, unless a newer event on the
+// same path already replaced it. Without this, known would grow by one
+// entry for every path ever seen and never shrink back down.
+func (d *debouncer) clearKnown(name string, fi os.FileInfo) {
+	d.Lock()
+	defer d.Unlock()
+	if cur, ok := d.known[name]; ok && os.SameFile(cur, fi) {
+		delete(d.known, name)
+	}
+}
+
+// close stops every pending timer and drops all buffered state, so that a
+// debounce scheduled before the owning Watcher was closed can no longer
+// fire and call back into user code afterward.
+func (d *debouncer) close() {
+	d.Lock()
+	defer d.Unlock()
+	for name, p := range d.pending {
+		p.timer.Stop()
+		delete(d.pending, name)
+	}
+	d.exits = make(map[string]exitInfo)
+	d.known = make(map[string]os.FileInfo)
+}
+
+func (d *debouncer) flush(name string) {
+	d.Lock()
+	p, ok := d.pending[name]
+	if ok {
+		delete(d.pending, name)
+	}
+	d.Unlock()
+	if !ok {
+		return
+	}
+	d.w.Lock()
+	defer d.w.Unlock()
+	d.w.apply(fsnotify.Event{Name: name, Op: p.op})
+}
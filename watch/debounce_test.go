@@ -0,0 +1,36 @@
+// Copyright 2014 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package watch
+
+import (
+	"testing"
+
+	"gopkg.in/fsnotify.v1"
+)
+
+func TestCoalesce(t *testing.T) {
+	tests := []struct {
+		name       string
+		pending    fsnotify.Op
+		next       fsnotify.Op
+		wantMerged fsnotify.Op
+		wantDrop   bool
+	}{
+		{"create then remove cancels out", fsnotify.Create, fsnotify.Remove, 0, true},
+		{"create then write stays create", fsnotify.Create, fsnotify.Write, fsnotify.Create, false},
+		{"write then write stays write", fsnotify.Write, fsnotify.Write, fsnotify.Write, false},
+		{"write then remove becomes remove", fsnotify.Write, fsnotify.Remove, fsnotify.Remove, false},
+		{"write then chmod becomes chmod", fsnotify.Write, fsnotify.Chmod, fsnotify.Chmod, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, drop := coalesce(tt.pending, tt.next)
+			if merged != tt.wantMerged || drop != tt.wantDrop {
+				t.Errorf("coalesce(%v, %v) = (%v, %v), want (%v, %v)",
+					tt.pending, tt.next, merged, drop, tt.wantMerged, tt.wantDrop)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+// Copyright 2014 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package watch
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileIno returns fi's inode number, when the platform's os.FileInfo
+// exposes one.
+func fileIno(fi os.FileInfo) (uint64, bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(st.Ino), true
+}
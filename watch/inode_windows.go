@@ -0,0 +1,13 @@
+// Copyright 2014 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package watch
+
+import "os"
+
+// fileIno always reports no inode on Windows, which has no equivalent
+// exposed through os.FileInfo; callers fall back to mtime/size/mode.
+func fileIno(fi os.FileInfo) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,226 @@
+// Copyright 2014 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gopkg.in/fsnotify.v1"
+)
+
+// DefaultPollInterval is used by NewWatcher when it falls back to polling
+// and no interval is given explicitly.
+const DefaultPollInterval = 2 * time.Second
+
+// snapshot records enough of a file or directory's state to detect changes
+// on the next poll without relying on OS-level notifications.
+type snapshot struct {
+	modTime time.Time
+	size    int64
+	mode    os.FileMode
+	ino     uint64 // 0 and hasIno false if the platform can't report one
+	hasIno  bool
+	entries map[string]os.FileInfo // directory children, nil for files
+}
+
+// pollBackend is a Backend that periodically os.Stats tracked files and
+// os.ReadDirs tracked directories, diffing against the last-known snapshot
+// to synthesize fsnotify-compatible events. It is the fallback used on
+// filesystems where fsnotify is unreliable or unavailable.
+type pollBackend struct {
+	sync.Mutex
+	interval time.Duration
+	tracked  map[string]bool // name -> isDir, at time of Add
+	snaps    map[string]snapshot
+	events   chan fsnotify.Event
+	errors   chan error
+	done     chan struct{}
+	closed   bool
+}
+
+func newPollBackend(interval time.Duration) *pollBackend {
+	b := &pollBackend{
+		interval: interval,
+		tracked:  make(map[string]bool),
+		snaps:    make(map[string]snapshot),
+		events:   make(chan fsnotify.Event),
+		errors:   make(chan error),
+		done:     make(chan struct{}),
+	}
+	go b.loop()
+	return b
+}
+
+func (b *pollBackend) Add(name string) error {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	b.Lock()
+	defer b.Unlock()
+	b.tracked[name] = fi.IsDir()
+	b.snaps[name] = b.snap(name, fi)
+	return nil
+}
+
+func (b *pollBackend) Remove(name string) error {
+	b.Lock()
+	defer b.Unlock()
+	delete(b.tracked, name)
+	delete(b.snaps, name)
+	return nil
+}
+
+func (b *pollBackend) Events() <-chan fsnotify.Event { return b.events }
+func (b *pollBackend) Errors() <-chan error          { return b.errors }
+
+func (b *pollBackend) Close() error {
+	b.Lock()
+	if b.closed {
+		b.Unlock()
+		return nil
+	}
+	b.closed = true
+	b.Unlock()
+	close(b.done)
+	return nil
+}
+
+func (b *pollBackend) loop() {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.done:
+			close(b.events)
+			close(b.errors)
+			return
+		case <-ticker.C:
+			b.poll()
+		}
+	}
+}
+
+func (b *pollBackend) poll() {
+	b.Lock()
+	names := make([]string, 0, len(b.tracked))
+	for name := range b.tracked {
+		names = append(names, name)
+	}
+	b.Unlock()
+	for _, name := range names {
+		b.pollOne(name)
+	}
+}
+
+func (b *pollBackend) pollOne(name string) {
+	fi, err := os.Stat(name)
+	b.Lock()
+	old, existed := b.snaps[name]
+	b.Unlock()
+	if err != nil {
+		if os.IsNotExist(err) {
+			if existed {
+				b.Lock()
+				delete(b.snaps, name)
+				b.Unlock()
+				b.emit(fsnotify.Event{Name: name, Op: fsnotify.Remove})
+			}
+			return
+		}
+		b.fail(err)
+		return
+	}
+	next := b.snap(name, fi)
+	switch {
+	case !existed:
+		// Nothing to diff against yet; just start tracking.
+	case fi.IsDir():
+		b.diffDir(name, old, next)
+	case changed(old, next):
+		b.emit(fsnotify.Event{Name: name, Op: fsnotify.Write})
+	}
+	b.Lock()
+	b.snaps[name] = next
+	b.Unlock()
+}
+
+// changed reports whether next looks like a different file than old: a
+// different mtime/size/mode, or - when the platform can report one - a
+// different inode even if mtime/size/mode happen to match, which catches
+// an atomic-replace editor swapping in a new file with the old one's
+// stat times preserved.
+func changed(old, next snapshot) bool {
+	if next.modTime != old.modTime || next.size != old.size || next.mode != old.mode {
+		return true
+	}
+	return old.hasIno && next.hasIno && old.ino != next.ino
+}
+
+func (b *pollBackend) snap(name string, fi os.FileInfo) snapshot {
+	s := snapshot{modTime: fi.ModTime(), size: fi.Size(), mode: fi.Mode()}
+	s.ino, s.hasIno = fileIno(fi)
+	if !fi.IsDir() {
+		return s
+	}
+	entries, err := os.ReadDir(name)
+	if err != nil {
+		return s
+	}
+	s.entries = make(map[string]os.FileInfo, len(entries))
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil {
+			s.entries[e.Name()] = info
+		}
+	}
+	return s
+}
+
+// diffDir synthesizes Create/Write/Remove events for children that
+// appeared, changed, or disappeared between two directory snapshots. This
+// is also how a poll-backed Watcher notices writes to files it never
+// Added individually, mirroring how an inotify/kqueue watch on a
+// directory reports changes to its children for free.
+func (b *pollBackend) diffDir(dir string, old, next snapshot) {
+	for name, fi := range next.entries {
+		prev, existed := old.entries[name]
+		path := filepath.Join(dir, name)
+		if !existed {
+			b.emit(fsnotify.Event{Name: path, Op: fsnotify.Create})
+			continue
+		}
+		if fi.ModTime() != prev.ModTime() || fi.Size() != prev.Size() || fi.Mode() != prev.Mode() {
+			b.emit(fsnotify.Event{Name: path, Op: fsnotify.Write})
+			continue
+		}
+		if prevIno, prevOK := fileIno(prev); prevOK {
+			if nextIno, nextOK := fileIno(fi); nextOK && nextIno != prevIno {
+				b.emit(fsnotify.Event{Name: path, Op: fsnotify.Write})
+			}
+		}
+	}
+	for name := range old.entries {
+		if _, ok := next.entries[name]; !ok {
+			b.emit(fsnotify.Event{Name: filepath.Join(dir, name), Op: fsnotify.Remove})
+		}
+	}
+}
+
+func (b *pollBackend) emit(ev fsnotify.Event) {
+	select {
+	case b.events <- ev:
+	case <-b.done:
+	}
+}
+
+func (b *pollBackend) fail(err error) {
+	select {
+	case b.errors <- err:
+	case <-b.done:
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2014 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gopkg.in/fsnotify.v1"
+)
+
+type fakeFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi fakeFileInfo) Name() string       { return fi.name }
+func (fi fakeFileInfo) Size() int64        { return fi.size }
+func (fi fakeFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi fakeFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi fakeFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi fakeFileInfo) Sys() interface{}   { return nil }
+
+func TestPollBackendDiffDir(t *testing.T) {
+	now := time.Now()
+	dir := "/watched"
+
+	old := snapshot{entries: map[string]os.FileInfo{
+		"unchanged.txt": fakeFileInfo{name: "unchanged.txt", size: 1, modTime: now},
+		"edited.txt":    fakeFileInfo{name: "edited.txt", size: 1, modTime: now},
+		"gone.txt":      fakeFileInfo{name: "gone.txt", size: 1, modTime: now},
+	}}
+	next := snapshot{entries: map[string]os.FileInfo{
+		"unchanged.txt": fakeFileInfo{name: "unchanged.txt", size: 1, modTime: now},
+		"edited.txt":    fakeFileInfo{name: "edited.txt", size: 2, modTime: now.Add(time.Second)},
+		"new.txt":       fakeFileInfo{name: "new.txt", size: 1, modTime: now},
+	}}
+
+	b := newPollBackend(time.Hour)
+	defer b.Close()
+
+	got := make(map[string]fsnotify.Op)
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			ev := <-b.events
+			got[ev.Name] = ev.Op
+		}
+		close(done)
+	}()
+
+	b.diffDir(dir, old, next)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for diffDir events, got %v", got)
+	}
+
+	want := map[string]fsnotify.Op{
+		filepath.Join(dir, "new.txt"):    fsnotify.Create,
+		filepath.Join(dir, "edited.txt"): fsnotify.Write,
+		filepath.Join(dir, "gone.txt"):   fsnotify.Remove,
+	}
+	for name, op := range want {
+		if got[name] != op {
+			t.Errorf("diffDir event for %s = %v, want %v", name, got[name], op)
+		}
+	}
+	if _, ok := got[filepath.Join(dir, "unchanged.txt")]; ok {
+		t.Errorf("diffDir emitted an event for unchanged.txt")
+	}
+}
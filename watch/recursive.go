@@ -0,0 +1,156 @@
+// Copyright 2014 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package watch
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/bmatcuk/doublestar"
+
+	"github.com/limetext/backend/log"
+)
+
+type (
+	// Option configures a recursive watch, e.g. WithInclude/WithExclude.
+	Option func(*watchFilter)
+
+	// watchFilter holds the include/exclude globs for a recursive watch.
+	watchFilter struct {
+		include []string
+		exclude []string
+	}
+
+	// recursiveWatch remembers the callback and filter a recursive watch
+	// was set up with, so newly created subdirectories can be folded in
+	// with the same settings.
+	recursiveWatch struct {
+		cb     interface{}
+		filter *watchFilter
+	}
+)
+
+// WithInclude restricts a recursive watch to paths matching any of the
+// given doublestar-style globs, e.g. "**/*.py".
+func WithInclude(patterns ...string) Option {
+	return func(f *watchFilter) { f.include = append(f.include, patterns...) }
+}
+
+// WithExclude skips paths matching any of the given doublestar-style
+// globs, e.g. "**/node_modules/**".
+func WithExclude(patterns ...string) Option {
+	return func(f *watchFilter) { f.exclude = append(f.exclude, patterns...) }
+}
+
+// match reports whether path should be watched under this filter: it must
+// match one of the include globs (if any are set) and none of the
+// exclude globs.
+func (f *watchFilter) match(path string) bool {
+	if len(f.include) > 0 {
+		ok := false
+		for _, pattern := range f.include {
+			if m, _ := doublestar.Match(pattern, path); m {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	for _, pattern := range f.exclude {
+		if m, _ := doublestar.Match(pattern, path); m {
+			return false
+		}
+	}
+	return true
+}
+
+// WatchRecursive walks root, watching every subdirectory, and registers cb
+// on every file matching the include/exclude options. Subdirectories
+// created later under root are automatically picked up, closing the race
+// where files land in a directory between mkdir and Add.
+func (w *Watcher) WatchRecursive(root string, cb interface{}, opts ...Option) error {
+	w.upgradeForRecursive()
+	f := &watchFilter{}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return w.watchRecursive(root, cb, f, false)
+}
+
+// watchRecursive is the shared implementation behind WatchRecursive and
+// the auto-extension performed when a new subdirectory appears. synthetic
+// controls whether a Create event is dispatched for pre-existing files
+// that match the filter, which is only desired when extending into a directory that
+// already existed before we started watching it.
+func (w *Watcher) watchRecursive(root string, cb interface{}, f *watchFilter, synthetic bool) error {
+	w.Lock()
+	rb, ok := w.wchr.(RecursiveBackend)
+	w.Unlock()
+	if ok {
+		if err := rb.AddRecursive(root); err != nil {
+			return err
+		}
+	}
+	return filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			if err := w.Watch(path, nil); err != nil {
+				return err
+			}
+			w.Lock()
+			w.recursive[path] = recursiveWatch{cb: cb, filter: f}
+			w.Unlock()
+			return nil
+		}
+		if !f.match(path) {
+			return nil
+		}
+		if err := w.Watch(path, cb); err != nil {
+			return err
+		}
+		if synthetic {
+			w.dispatch(cb, Event{Name: path, Op: Create})
+		}
+		return nil
+	})
+}
+
+// extendRecursive folds a newly created entry into the recursive watch
+// registered on its parent, if any. A new directory gets watches for
+// itself and its contents, with synthetic Create events for the files
+// already inside that match the filter (subdirectories get no Create
+// event of their own); a new file matching the watch's filter is watched and
+// reported directly, covering the common case of a file landing in a
+// directory that was already part of the tree.
+func (w *Watcher) extendRecursive(parent, name string) {
+	w.Lock()
+	rw, ok := w.recursive[parent]
+	w.Unlock()
+	if !ok {
+		return
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		return
+	}
+	if fi.IsDir() {
+		if err := w.watchRecursive(name, rw.cb, rw.filter, true); err != nil {
+			log.Error("Couldn't extend recursive watch to %s: %s", name, err)
+		}
+		return
+	}
+	if !rw.filter.match(name) {
+		return
+	}
+	if err := w.Watch(name, rw.cb); err != nil {
+		log.Error("Couldn't watch %s under recursive root: %s", name, err)
+		return
+	}
+	w.dispatch(rw.cb, Event{Name: name, Op: Create})
+}
@@ -0,0 +1,81 @@
+// Copyright 2014 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchFilterMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		path    string
+		want    bool
+	}{
+		{"no filters matches everything", nil, nil, "foo/bar.py", true},
+		{"include match", []string{"**/*.py"}, nil, "foo/bar.py", true},
+		{"include mismatch", []string{"**/*.py"}, nil, "foo/bar.go", false},
+		{"exclude wins over include", []string{"**/*.py"}, []string{"**/vendor/**"}, "vendor/bar.py", false},
+		{"exclude only, no match", nil, []string{"**/node_modules/**"}, "foo/bar.py", true},
+		{"exclude only, match", nil, []string{"**/node_modules/**"}, "node_modules/bar.py", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &watchFilter{include: tt.include, exclude: tt.exclude}
+			if got := f.match(tt.path); got != tt.want {
+				t.Errorf("match(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+type capturingCreated struct {
+	created chan string
+}
+
+func (c *capturingCreated) FileCreated(name string) { c.created <- name }
+
+// TestWatchRecursiveNewFileInExistingDir drives a real filesystem and a
+// live Observe loop to check that a file created in a directory that was
+// already part of the recursive tree (as opposed to a newly created
+// subdirectory) is still reported.
+func TestWatchRecursiveNewFileInExistingDir(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "pkgA")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewPollingWatcher(20 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	go w.Observe()
+
+	cb := &capturingCreated{created: make(chan string, 1)}
+	if err := w.WatchRecursive(root, cb, WithInclude("**/*.settings")); err != nil {
+		t.Fatal(err)
+	}
+
+	target := filepath.Join(sub, "new.settings")
+	if err := os.WriteFile(target, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case got := <-cb.created:
+		if got != target {
+			t.Errorf("FileCreated(%q), want %q", got, target)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for FileCreated on a new file in an already-watched recursive dir")
+	}
+}
@@ -5,16 +5,21 @@
 package watch
 
 import (
+	"context"
 	"errors"
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/limetext/backend/log"
 	"github.com/limetext/util"
 	"gopkg.in/fsnotify.v1"
 )
 
+// ErrClosed is returned by Watch/UnWatch once the Watcher has been Closed.
+var ErrClosed = errors.New("watch: watcher is closed")
+
 type (
 	// Wrapper around fsnotify watcher to suit lime needs
 	// 	- Watching directories, we will have less individual watchers
@@ -23,10 +28,13 @@ type (
 	// 	- Watching and applying action on certain events
 	Watcher struct {
 		sync.Mutex
-		wchr     *fsnotify.Watcher
-		watched  map[string][]interface{}
-		watchers []string // paths we created watcher on
-		dirs     []string // dirs we are watching
+		wchr      Backend
+		watched   map[string][]interface{}
+		watchers  []string                  // paths we created watcher on
+		dirs      []string                  // dirs we are watching
+		recursive map[string]recursiveWatch // dirs watched via WatchRecursive
+		deb       *debouncer                // non-nil once SetDebounce is given a positive window
+		closed    bool
 	}
 
 	// Called on file change directories won't recieve this callback
@@ -51,19 +59,71 @@ type (
 	}
 )
 
+// NewWatcher creates a fsnotify-backed Watcher. On platforms or
+// filesystems where fsnotify can't be initialized (some NFS/SMB mounts,
+// containers without inotify, ...) it falls back to a polling Backend so
+// callers still get FileChanged/Created/Removed/Renamed callbacks.
 func NewWatcher() (*Watcher, error) {
-	wchr, err := fsnotify.NewWatcher()
+	b, err := newFsnotifyBackend()
 	if err != nil {
-		return nil, err
+		log.Warn("Couldn't create fsnotify watcher, falling back to polling: %s", err)
+		return NewPollingWatcher(DefaultPollInterval)
 	}
-	w := &Watcher{wchr: wchr}
+	return NewWatcherWithBackend(b)
+}
+
+// NewPollingWatcher creates a Watcher that polls the filesystem every
+// interval instead of relying on OS-level change notifications.
+func NewPollingWatcher(interval time.Duration) (*Watcher, error) {
+	return NewWatcherWithBackend(newPollBackend(interval))
+}
+
+// NewWatcherWithBackend creates a Watcher driven by the given Backend,
+// allowing callers to choose fsnotify, polling, or any other
+// implementation of the Backend interface.
+func NewWatcherWithBackend(b Backend) (*Watcher, error) {
+	w := &Watcher{wchr: b}
 	w.watched = make(map[string][]interface{})
 	w.watchers = make([]string, 0)
 	w.dirs = make([]string, 0)
+	w.recursive = make(map[string]recursiveWatch)
 
 	return w, nil
 }
 
+// SetDebounce coalesces bursts of events on the same path into a single
+// callback invocation, firing it only once no further events on that
+// path arrive for the given quiet window. A window of zero (the
+// default) disables coalescing and delivers events as they happen.
+func (w *Watcher) SetDebounce(d time.Duration) {
+	w.Lock()
+	defer w.Unlock()
+	if d <= 0 {
+		w.deb = nil
+		return
+	}
+	w.deb = newDebouncer(w, d)
+}
+
+// Close closes the underlying Backend and causes any running
+// Observe/ObserveContext to return once its channels drain. After Close,
+// Watch and UnWatch return ErrClosed instead of operating on (or
+// panicking on) a torn-down watcher.
+func (w *Watcher) Close() error {
+	w.Lock()
+	if w.closed {
+		w.Unlock()
+		return nil
+	}
+	w.closed = true
+	deb := w.deb
+	w.Unlock()
+	if deb != nil {
+		deb.close()
+	}
+	return w.wchr.Close()
+}
+
 func (w *Watcher) Watch(name string, cb interface{}) error {
 	log.Finest("Watch(%s)", name)
 	fi, err := os.Stat(name)
@@ -78,6 +138,9 @@ func (w *Watcher) Watch(name string, cb interface{}) error {
 	}
 	w.Lock()
 	defer w.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
 	if err := w.add(name, cb); err != nil {
 		if !isDir {
 			return err
@@ -118,6 +181,9 @@ func (w *Watcher) add(name string, cb interface{}) error {
 	if _, ok := cb.(FileRenamedCallback); ok {
 		numok++
 	}
+	if _, ok := cb.(EventCallback); ok {
+		numok++
+	}
 	if numok == 0 {
 		return errors.New("The callback argument does satisfy any File*Callback interfaces")
 	}
@@ -153,6 +219,9 @@ func (w *Watcher) UnWatch(name string, cb interface{}) error {
 	log.Finest("UnWatch(%s)", name)
 	w.Lock()
 	defer w.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
 	if cb == nil {
 		return w.unWatch(name)
 	}
@@ -205,16 +274,45 @@ func (w *Watcher) removeDir(name string) {
 // Observe dispatches notifications received by the watcher. This function will
 // return when the watcher is closed.
 func (w *Watcher) Observe() {
+	w.ObserveContext(context.Background())
+}
+
+// ObserveContext is Observe, but also returns as soon as ctx is done,
+// leaving the Watcher itself open.
+func (w *Watcher) ObserveContext(ctx context.Context) {
 	for {
+		// wchr can be swapped out from under us by upgradeForRecursive
+		// while this loop is running in another goroutine (that's the
+		// documented way to call WatchRecursive), so its Events/Errors
+		// channels must be read under the lock rather than evaluated
+		// directly in the select below.
+		w.Lock()
+		events := w.wchr.Events()
+		errs := w.wchr.Errors()
+		w.Unlock()
 		select {
-		case ev, ok := <-w.wchr.Events:
+		case <-ctx.Done():
+			return
+		case ev, ok := <-events:
 			if !ok {
-				break
+				// A swapped-out backend's channels are closed when it
+				// is; keep going on the new one rather than returning.
+				w.Lock()
+				swapped := w.wchr.Events() != events
+				w.Unlock()
+				if swapped {
+					continue
+				}
+				return
 			}
 			func() {
 				w.Lock()
 				defer w.Unlock()
-				w.apply(ev)
+				if w.deb != nil {
+					w.deb.handle(ev)
+				} else {
+					w.apply(ev)
+				}
 				name := ev.Name
 				// currently fsnotify pushs remove event for files
 				// inside directory when a directory is removed but
@@ -238,24 +336,38 @@ func (w *Watcher) Observe() {
 					w.Watch(dir, nil)
 					w.Lock()
 				}
-				// If the event is create we will apply FileCreated callback
-				// for the parent directory to because when new file is created
-				// inside directory we won't get any event for the watched directory.
+				// If the event is create we will apply the Create callbacks
+				// for the parent directory too because when a new file is
+				// created inside a directory we won't get any event for the
+				// watched directory itself.
 				// we need this feature to detect new packages(plugins, settings, etc)
 				if cbs, exist := w.watched[dir]; ev.Op&fsnotify.Create != 0 && exist {
+					dirEv := Event{Name: name, Op: fsnotify.Create}
 					for _, cb := range cbs {
-						if c, ok := cb.(FileCreatedCallback); ok {
-							w.Unlock()
-							c.FileCreated(name)
-							w.Lock()
-						}
+						w.Unlock()
+						w.dispatch(cb, dirEv)
+						w.Lock()
 					}
 				}
+				// If a new directory appears under a WatchRecursive root,
+				// fold it into the recursive watch instead of leaving it
+				// unwatched until the caller notices and re-subscribes.
+				if ev.Op&fsnotify.Create != 0 {
+					w.Unlock()
+					w.extendRecursive(dir, name)
+					w.Lock()
+				}
 
 			}()
-		case err, ok := <-w.wchr.Errors:
+		case err, ok := <-errs:
 			if !ok {
-				break
+				w.Lock()
+				swapped := w.wchr.Errors() != errs
+				w.Unlock()
+				if swapped {
+					continue
+				}
+				return
 			}
 			log.Warn("Watcher error: %s", err)
 		}
@@ -264,25 +376,35 @@ func (w *Watcher) Observe() {
 
 func (w *Watcher) apply(ev fsnotify.Event) {
 	for _, cb := range w.watched[ev.Name] {
-		if ev.Op&fsnotify.Create != 0 {
-			if c, ok := cb.(FileCreatedCallback); ok {
-				c.FileCreated(ev.Name)
-			}
+		w.dispatch(cb, ev)
+	}
+}
+
+// dispatch routes a single event to a single callback, whichever form it
+// takes: the raw EventCallback, or one or more of the legacy File*Callback
+// interfaces.
+func (w *Watcher) dispatch(cb interface{}, ev Event) {
+	if c, ok := cb.(EventCallback); ok {
+		c.OnEvent(ev)
+	}
+	if ev.Op&fsnotify.Create != 0 {
+		if c, ok := cb.(FileCreatedCallback); ok {
+			c.FileCreated(ev.Name)
 		}
-		if ev.Op&fsnotify.Write != 0 {
-			if c, ok := cb.(FileChangedCallback); ok {
-				c.FileChanged(ev.Name)
-			}
+	}
+	if ev.Op&fsnotify.Write != 0 {
+		if c, ok := cb.(FileChangedCallback); ok {
+			c.FileChanged(ev.Name)
 		}
-		if ev.Op&fsnotify.Remove != 0 {
-			if c, ok := cb.(FileRemovedCallback); ok {
-				c.FileRemoved(ev.Name)
-			}
+	}
+	if ev.Op&fsnotify.Remove != 0 {
+		if c, ok := cb.(FileRemovedCallback); ok {
+			c.FileRemoved(ev.Name)
 		}
-		if ev.Op&fsnotify.Rename != 0 {
-			if c, ok := cb.(FileRenamedCallback); ok {
-				c.FileRenamed(ev.Name)
-			}
+	}
+	if ev.Op&fsnotify.Rename != 0 {
+		if c, ok := cb.(FileRenamedCallback); ok {
+			c.FileRenamed(ev.Name)
 		}
 	}
 }
@@ -0,0 +1,198 @@
+// Copyright 2014 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+//go:build darwin
+// +build darwin
+
+package watch
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsevents"
+	"gopkg.in/fsnotify.v1"
+
+	"github.com/limetext/backend/log"
+)
+
+// fseventsBackend is a Backend built on macOS's CoreServices FSEvents API.
+// Unlike the kqueue backend fsnotify uses on darwin, which needs one file
+// descriptor per watched file or directory, a single FSEvents stream
+// covers an entire subtree, so it scales to large recursively-watched
+// roots such as a Packages/ directory. It implements RecursiveBackend:
+// AddRecursive registers one stream per root, and Add becomes a no-op for
+// any path already covered by one.
+type fseventsBackend struct {
+	mu      sync.Mutex
+	events  chan fsnotify.Event
+	errors  chan error
+	streams map[string]*fsevents.EventStream
+	// live tracks, per stream root, whether FSEvents has finished
+	// replaying historical events recorded before the stream started.
+	// Those are irrelevant to us (Watch already scans current state)
+	// so we drop everything up to the HistoryDone marker.
+	live map[string]bool
+	wg   sync.WaitGroup // outstanding drain goroutines, so Close can wait before closing channels
+}
+
+func newFSEventsBackend() (*fseventsBackend, error) {
+	return &fseventsBackend{
+		events:  make(chan fsnotify.Event),
+		errors:  make(chan error),
+		streams: make(map[string]*fsevents.EventStream),
+		live:    make(map[string]bool),
+	}, nil
+}
+
+// Add registers name, unless it already falls under a stream started for
+// one of its ancestors (the common case: WatchRecursive calls
+// AddRecursive on the root, then Watch on every subdirectory it walks).
+func (b *fseventsBackend) Add(name string) error {
+	return b.addStream(name)
+}
+
+// AddRecursive registers a single stream covering root's entire subtree.
+// Individual Add calls the caller later makes for paths under root become
+// no-ops against it.
+func (b *fseventsBackend) AddRecursive(root string) error {
+	return b.addStream(root)
+}
+
+func (b *fseventsBackend) addStream(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.coveredLocked(name) {
+		return nil
+	}
+	stream := &fsevents.EventStream{
+		Paths:   []string{name},
+		Latency: 100 * time.Millisecond,
+		Flags:   fsevents.FileEvents,
+	}
+	stream.Start()
+	b.streams[name] = stream
+	b.wg.Add(1)
+	go b.drain(name, stream)
+	return nil
+}
+
+// coveredLocked reports whether name is name itself or a descendant of an
+// already-registered stream root. Callers must hold b.mu.
+func (b *fseventsBackend) coveredLocked(name string) bool {
+	for root := range b.streams {
+		if name == root || strings.HasPrefix(name, root+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *fseventsBackend) Remove(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stream, ok := b.streams[name]
+	if !ok {
+		return nil
+	}
+	stream.Stop()
+	delete(b.streams, name)
+	delete(b.live, name)
+	return nil
+}
+
+func (b *fseventsBackend) Events() <-chan fsnotify.Event { return b.events }
+func (b *fseventsBackend) Errors() <-chan error          { return b.errors }
+
+func (b *fseventsBackend) Close() error {
+	b.mu.Lock()
+	for name, stream := range b.streams {
+		stream.Stop()
+		delete(b.streams, name)
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+	close(b.events)
+	close(b.errors)
+	return nil
+}
+
+// drain translates one stream's FSEvents messages into fsnotify-shaped
+// events until the stream is stopped.
+func (b *fseventsBackend) drain(root string, stream *fsevents.EventStream) {
+	defer b.wg.Done()
+	for msg := range stream.Events {
+		for _, ev := range msg {
+			if ev.Flags&fsevents.HistoryDone != 0 {
+				b.mu.Lock()
+				b.live[root] = true
+				b.mu.Unlock()
+				continue
+			}
+			b.mu.Lock()
+			live := b.live[root]
+			b.mu.Unlock()
+			if !live {
+				continue
+			}
+			if ev.Flags&fsevents.MustScanSubDirs != 0 {
+				// FSEvents coalesced a burst of changes under this path
+				// into one "go rescan" marker instead of individual
+				// events; surface it as a Write on the directory itself.
+				// The individual changes under it are not reported, and
+				// the directory is not rescanned.
+				b.events <- fsnotify.Event{Name: ev.Path, Op: fsnotify.Write}
+				continue
+			}
+			b.events <- translateFSEvent(ev)
+		}
+	}
+}
+
+func translateFSEvent(ev fsevents.Event) fsnotify.Event {
+	var op fsnotify.Op
+	if ev.Flags&fsevents.ItemCreated != 0 {
+		op |= fsnotify.Create
+	}
+	if ev.Flags&fsevents.ItemModified != 0 {
+		op |= fsnotify.Write
+	}
+	if ev.Flags&fsevents.ItemInodeMetaMod != 0 {
+		op |= fsnotify.Chmod
+	}
+	if ev.Flags&fsevents.ItemRemoved != 0 {
+		op |= fsnotify.Remove
+	}
+	if ev.Flags&fsevents.ItemRenamed != 0 {
+		op |= fsnotify.Rename
+	}
+	return fsnotify.Event{Name: ev.Path, Op: op}
+}
+
+// upgradeForRecursive swaps a plain kqueue-backed Watcher over to the
+// FSEvents backend the first time WatchRecursive is used, since that is
+// where kqueue's one-fd-per-entry scaling problem actually bites.
+// Watches already registered are carried over to the new backend.
+func (w *Watcher) upgradeForRecursive() {
+	w.Lock()
+	defer w.Unlock()
+	if _, ok := w.wchr.(*fsnotifyBackend); !ok {
+		return
+	}
+	fb, err := newFSEventsBackend()
+	if err != nil {
+		log.Warn("Couldn't start FSEvents backend, keeping kqueue: %s", err)
+		return
+	}
+	old := w.wchr
+	w.wchr = fb
+	for _, name := range w.watchers {
+		if err := fb.Add(name); err != nil {
+			log.Error("Couldn't migrate watch on %s to FSEvents: %s", name, err)
+		}
+	}
+	old.Close()
+}
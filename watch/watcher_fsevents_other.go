@@ -0,0 +1,13 @@
+// Copyright 2014 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+//go:build !darwin
+// +build !darwin
+
+package watch
+
+// upgradeForRecursive is a no-op outside darwin; the fsnotify backend
+// already uses the kernel's native recursive-friendly mechanism (inotify)
+// or polling, so there is nothing to swap in.
+func (w *Watcher) upgradeForRecursive() {}
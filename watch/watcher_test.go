@@ -0,0 +1,49 @@
+// Copyright 2014 The lime Authors.
+// Use of this source code is governed by a 2-clause
+// BSD-style license that can be found in the LICENSE file.
+
+package watch
+
+import "testing"
+
+type recordingCallback struct {
+	events  []Event
+	changed bool
+	created bool
+	removed bool
+	renamed bool
+}
+
+func (c *recordingCallback) OnEvent(ev Event)   { c.events = append(c.events, ev) }
+func (c *recordingCallback) FileChanged(string) { c.changed = true }
+func (c *recordingCallback) FileCreated(string) { c.created = true }
+func (c *recordingCallback) FileRemoved(string) { c.removed = true }
+func (c *recordingCallback) FileRenamed(string) { c.renamed = true }
+
+func TestDispatchChmod(t *testing.T) {
+	w := &Watcher{}
+	cb := &recordingCallback{}
+
+	w.dispatch(cb, Event{Name: "foo", Op: Chmod})
+
+	if len(cb.events) != 1 || cb.events[0].Op != Chmod {
+		t.Errorf("OnEvent got %v, want one Chmod event", cb.events)
+	}
+	if cb.changed || cb.created || cb.removed || cb.renamed {
+		t.Errorf("Chmod-only event triggered a legacy File*Callback method: %+v", cb)
+	}
+}
+
+func TestDispatchCreate(t *testing.T) {
+	w := &Watcher{}
+	cb := &recordingCallback{}
+
+	w.dispatch(cb, Event{Name: "foo", Op: Create})
+
+	if !cb.created {
+		t.Errorf("Create event didn't call FileCreated")
+	}
+	if cb.changed || cb.removed || cb.renamed {
+		t.Errorf("Create event triggered an unrelated File*Callback method: %+v", cb)
+	}
+}